@@ -0,0 +1,83 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package metrics holds the Prometheus collectors darkflare's server (and,
+// eventually, client) report tunnel activity through, so both binaries stay
+// consistent about names and labels without importing each other.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// route is the label every collector here carries: the matched config route
+// name, or "default" when -config isn't in use.
+const routeLabel = "route"
+
+var (
+	SessionsActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "darkflare_sessions_active",
+		Help: "Number of tunnel sessions currently open.",
+	}, []string{routeLabel})
+
+	BytesIn = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "darkflare_bytes_in_total",
+		Help: "Bytes received from clients and written to the backend.",
+	}, []string{routeLabel})
+
+	BytesOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "darkflare_bytes_out_total",
+		Help: "Bytes read from the backend and sent to clients.",
+	}, []string{routeLabel})
+
+	DialErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "darkflare_dial_errors_total",
+		Help: "Backend dial failures.",
+	}, []string{routeLabel})
+
+	SessionAgeSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "darkflare_session_age_seconds",
+		Help:    "Age of a session at the time it was closed.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12), // 1s .. ~34min
+	}, []string{routeLabel})
+)
+
+func init() {
+	prometheus.MustRegister(SessionsActive, BytesIn, BytesOut, DialErrorsTotal, SessionAgeSeconds)
+}
+
+// SessionOpened records a newly dialed session for route.
+func SessionOpened(route string) {
+	SessionsActive.WithLabelValues(route).Inc()
+}
+
+// SessionClosed records a session ending, reporting its age.
+func SessionClosed(route string, age time.Duration) {
+	SessionsActive.WithLabelValues(route).Dec()
+	SessionAgeSeconds.WithLabelValues(route).Observe(age.Seconds())
+}
+
+// DialError records a failed backend dial for route.
+func DialError(route string) {
+	DialErrorsTotal.WithLabelValues(route).Inc()
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package psk
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrReplay is returned by Opener.Open when a nonce at or below one already seen
+// is presented, which indicates a replayed or reordered ciphertext.
+var ErrReplay = errors.New("psk: nonce replay detected")
+
+// Direction labels the traffic flow a derived key protects, so the two
+// directions of a session never reuse the same key+nonce space.
+type Direction string
+
+const (
+	ClientToServer Direction = "c2s"
+	ServerToClient Direction = "s2c"
+)
+
+// DeriveKey derives a per-session, per-direction ChaCha20-Poly1305 key from
+// the shared psk and sessionID via HKDF-SHA256, so the CDN-visible hex
+// payload stays confidential end-to-end even when the CDN terminates TLS.
+func DeriveKey(psk []byte, sessionID string, dir Direction) (cipher.AEAD, error) {
+	h := hkdf.New(sha256.New, psk, []byte(sessionID), []byte("darkflare-session-key|"+string(dir)))
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(h, key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+// Sealer seals plaintext session bytes with a strictly increasing 64-bit
+// nonce counter so the peer can detect replay or reordering.
+type Sealer struct {
+	aead    cipher.AEAD
+	counter uint64
+}
+
+// NewSealer wraps aead with sequential-nonce framing.
+func NewSealer(aead cipher.AEAD) *Sealer {
+	return &Sealer{aead: aead}
+}
+
+// Seal encrypts plaintext and returns nonce||ciphertext.
+func (s *Sealer) Seal(plaintext []byte) []byte {
+	s.counter++
+	nonce := make([]byte, s.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], s.counter)
+	return s.aead.Seal(nonce, nonce, plaintext, nil)
+}
+
+// Opener decrypts frames produced by a peer's Sealer, rejecting any nonce
+// that does not strictly increase to prevent replay.
+type Opener struct {
+	aead   cipher.AEAD
+	lastCt uint64
+}
+
+// NewOpener wraps aead with sequential-nonce replay detection.
+func NewOpener(aead cipher.AEAD) *Opener {
+	return &Opener{aead: aead}
+}
+
+// Open decrypts a nonce||ciphertext frame produced by Seal.
+func (o *Opener) Open(frame []byte) ([]byte, error) {
+	ns := o.aead.NonceSize()
+	if len(frame) < ns {
+		return nil, fmt.Errorf("psk: frame shorter than nonce")
+	}
+	nonce, ciphertext := frame[:ns], frame[ns:]
+
+	ctr := binary.BigEndian.Uint64(nonce[len(nonce)-8:])
+	if ctr <= o.lastCt {
+		return nil, ErrReplay
+	}
+
+	plaintext, err := o.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+	o.lastCt = ctr
+	return plaintext, nil
+}
@@ -0,0 +1,115 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package psk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealerOpenerRoundTrip(t *testing.T) {
+	aead, err := DeriveKey([]byte("test-psk"), "session-123", ClientToServer)
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	sealer := NewSealer(aead)
+	opener := NewOpener(aead)
+
+	for i, want := range [][]byte{[]byte("hello"), []byte("world"), {}, []byte("third message")} {
+		frame := sealer.Seal(want)
+		got, err := opener.Open(frame)
+		if err != nil {
+			t.Fatalf("message %d: Open: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("message %d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestOpenerRejectsReplay(t *testing.T) {
+	aead, err := DeriveKey([]byte("test-psk"), "session-123", ClientToServer)
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	sealer := NewSealer(aead)
+	opener := NewOpener(aead)
+
+	frame := sealer.Seal([]byte("first"))
+	if _, err := opener.Open(frame); err != nil {
+		t.Fatalf("first Open: %v", err)
+	}
+
+	// Replaying the exact same frame must be rejected.
+	if _, err := opener.Open(frame); err != ErrReplay {
+		t.Errorf("replayed Open err = %v, want %v", err, ErrReplay)
+	}
+}
+
+func TestOpenerRejectsReordering(t *testing.T) {
+	aead, err := DeriveKey([]byte("test-psk"), "session-123", ClientToServer)
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	sealer := NewSealer(aead)
+	opener := NewOpener(aead)
+
+	frame1 := sealer.Seal([]byte("first"))
+	frame2 := sealer.Seal([]byte("second"))
+
+	if _, err := opener.Open(frame2); err != nil {
+		t.Fatalf("Open frame2: %v", err)
+	}
+	// frame1's nonce is lower than the last accepted nonce, so it must be
+	// rejected even though it was never seen before by this Opener.
+	if _, err := opener.Open(frame1); err != ErrReplay {
+		t.Errorf("Open frame1 after frame2 err = %v, want %v", err, ErrReplay)
+	}
+}
+
+func TestOpenerRejectsShortFrame(t *testing.T) {
+	aead, err := DeriveKey([]byte("test-psk"), "session-123", ClientToServer)
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	opener := NewOpener(aead)
+
+	if _, err := opener.Open([]byte("short")); err == nil {
+		t.Error("Open on a too-short frame = nil error, want error")
+	}
+}
+
+func TestDeriveKeyDirectionsDiffer(t *testing.T) {
+	c2s, err := DeriveKey([]byte("test-psk"), "session-123", ClientToServer)
+	if err != nil {
+		t.Fatalf("DeriveKey c2s: %v", err)
+	}
+	s2c, err := DeriveKey([]byte("test-psk"), "session-123", ServerToClient)
+	if err != nil {
+		t.Fatalf("DeriveKey s2c: %v", err)
+	}
+
+	frame := NewSealer(c2s).Seal([]byte("hello"))
+	if _, err := NewOpener(s2c).Open(frame); err == nil {
+		t.Error("Open with the wrong direction's key succeeded, want error")
+	}
+}
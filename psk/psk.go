@@ -0,0 +1,89 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package psk implements the pre-shared-key scheme darkflare uses to
+// authenticate the X-Ephemeral header and to derive per-session AEAD keys.
+// It has no dependency on net/http so both the server and the client can
+// import it and stay bit-for-bit compatible.
+package psk
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxClockSkew is the default tolerance between the timestamp embedded in
+// an X-Ephemeral header and the server's clock.
+const MaxClockSkew = 30 * time.Second
+
+// BuildHeader returns the X-Ephemeral header value for sessionID at t:
+// "sessionID.timestamp.hexHMAC(sessionID|timestamp, psk)", where timestamp
+// is t in Unix seconds.
+func BuildHeader(psk []byte, sessionID string, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := sign(psk, sessionID, ts)
+	return fmt.Sprintf("%s.%s.%s", sessionID, ts, hex.EncodeToString(mac))
+}
+
+// VerifyHeader parses and authenticates an X-Ephemeral header produced by
+// BuildHeader, enforcing maxSkew against now. It returns the embedded
+// session ID on success.
+func VerifyHeader(psk []byte, header string, now time.Time, maxSkew time.Duration) (sessionID string, ok bool) {
+	parts := strings.SplitN(header, ".", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+	sessionID, ts, sig := parts[0], parts[1], parts[2]
+
+	want := sign(psk, sessionID, ts)
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(want, got) {
+		return "", false
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	skew := now.Sub(time.Unix(unix, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxSkew {
+		return "", false
+	}
+
+	return sessionID, true
+}
+
+// sign computes HMAC-SHA256(psk, sessionID|timestamp).
+func sign(psk []byte, sessionID, timestamp string) []byte {
+	mac := hmac.New(sha256.New, psk)
+	mac.Write([]byte(sessionID))
+	mac.Write([]byte("|"))
+	mac.Write([]byte(timestamp))
+	return mac.Sum(nil)
+}
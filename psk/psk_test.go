@@ -0,0 +1,102 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package psk
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyHeaderRoundTrip(t *testing.T) {
+	key := []byte("test-psk")
+	now := time.Now()
+	header := BuildHeader(key, "session-123", now)
+
+	sessionID, ok := VerifyHeader(key, header, now, MaxClockSkew)
+	if !ok {
+		t.Fatalf("VerifyHeader(%q) = false, want true", header)
+	}
+	if sessionID != "session-123" {
+		t.Errorf("sessionID = %q, want %q", sessionID, "session-123")
+	}
+}
+
+func TestVerifyHeaderRejectsTamperedInput(t *testing.T) {
+	key := []byte("test-psk")
+	now := time.Now()
+	header := BuildHeader(key, "session-123", now)
+
+	cases := []struct {
+		name   string
+		header string
+	}{
+		{"wrong key", func() string {
+			return BuildHeader([]byte("other-psk"), "session-123", now)
+		}()},
+		{"tampered session id", "session-999" + strings.TrimPrefix(header, "session-123")},
+		{"tampered signature", func() string {
+			last := "0"
+			if header[len(header)-1] == '0' {
+				last = "1"
+			}
+			return header[:len(header)-1] + last
+		}()},
+		{"missing parts", "session-123.12345"},
+		{"empty", ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, ok := VerifyHeader(key, tc.header, now, MaxClockSkew); ok {
+				t.Errorf("VerifyHeader(%q) = true, want false", tc.header)
+			}
+		})
+	}
+}
+
+func TestVerifyHeaderClockSkew(t *testing.T) {
+	key := []byte("test-psk")
+	signedAt := time.Unix(1_700_000_000, 0)
+
+	cases := []struct {
+		name   string
+		now    time.Time
+		maxErr bool
+	}{
+		{"within skew", signedAt.Add(10 * time.Second), false},
+		{"within skew, negative", signedAt.Add(-10 * time.Second), false},
+		{"exactly at boundary", signedAt.Add(MaxClockSkew), false},
+		{"beyond skew", signedAt.Add(MaxClockSkew + time.Second), true},
+		{"beyond skew, negative", signedAt.Add(-MaxClockSkew - time.Second), true},
+	}
+
+	header := BuildHeader(key, "session-123", signedAt)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ok := VerifyHeader(key, header, tc.now, MaxClockSkew)
+			if tc.maxErr == ok {
+				t.Errorf("VerifyHeader at now=%v = %v, want ok=%v", tc.now, ok, !tc.maxErr)
+			}
+		})
+	}
+}
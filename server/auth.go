@@ -0,0 +1,53 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ASkyeye/darkflare/psk"
+)
+
+// authenticateSession verifies the X-Ephemeral header against s.pskKey and,
+// on success, returns the embedded session ID along with the per-direction
+// AEADs used to seal responses and open requests for that session. It must
+// run before net.Dial so an unauthenticated request never reaches the
+// destination.
+func (s *Server) authenticateSession(r *http.Request) (sessionID string, sealer *psk.Sealer, opener *psk.Opener, ok bool) {
+	header := r.Header.Get("X-Ephemeral")
+	sessionID, ok = psk.VerifyHeader(s.pskKey, header, time.Now(), psk.MaxClockSkew)
+	if !ok {
+		return "", nil, nil, false
+	}
+
+	c2s, err := psk.DeriveKey(s.pskKey, sessionID, psk.ClientToServer)
+	if err != nil {
+		return "", nil, nil, false
+	}
+	s2c, err := psk.DeriveKey(s.pskKey, sessionID, psk.ServerToClient)
+	if err != nil {
+		return "", nil, nil, false
+	}
+
+	return sessionID, psk.NewSealer(s2c), psk.NewOpener(c2s), true
+}
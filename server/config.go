@@ -0,0 +1,120 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Matcher selects which requests a Route applies to. An empty field means
+// "don't care"; a Route matches only if every non-empty field it sets
+// matches the request. Matchers are evaluated in route-list order and the
+// first match wins, mirroring tlsrouter-style routing.
+type Matcher struct {
+	SNI            string `yaml:"sni"`
+	HostHeader     string `yaml:"host_header"`
+	PathPrefix     string `yaml:"path_prefix"`
+	CFWorkerHeader string `yaml:"cf_worker_header"`
+}
+
+// Route binds a Matcher to exactly one action: forward to a TCP backend,
+// launch an application, or reject the request outright.
+type Route struct {
+	Name    string  `yaml:"name"`
+	Match   Matcher `yaml:"match"`
+	Forward string  `yaml:"forward"`
+	App     string  `yaml:"app"`
+	Reject  bool    `yaml:"reject"`
+}
+
+// label identifies this route for metrics, falling back to its action when
+// the config doesn't set an explicit name.
+func (r *Route) label() string {
+	if r == nil {
+		return "default"
+	}
+	if r.Name != "" {
+		return r.Name
+	}
+	if r.Forward != "" {
+		return r.Forward
+	}
+	return r.App
+}
+
+// Config is the top-level -config document: an ordered list of routes plus
+// a fallback applied when nothing else matches.
+type Config struct {
+	Routes  []Route `yaml:"routes"`
+	Default *Route  `yaml:"default"`
+}
+
+// LoadConfig reads and parses a routing config from path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// matches reports whether r satisfies every non-empty field of m.
+func (m Matcher) matches(r *http.Request) bool {
+	if m.SNI != "" {
+		if r.TLS == nil || !strings.EqualFold(r.TLS.ServerName, m.SNI) {
+			return false
+		}
+	}
+	if m.HostHeader != "" && !strings.EqualFold(r.Host, m.HostHeader) {
+		return false
+	}
+	if m.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, m.PathPrefix) {
+		return false
+	}
+	if m.CFWorkerHeader != "" && r.Header.Get("Cf-Worker") != m.CFWorkerHeader {
+		return false
+	}
+	return true
+}
+
+// resolve returns the first route whose matcher matches r, falling back to
+// Default. The second return value is false only when no route and no
+// default apply, meaning the request should be rejected.
+func (c *Config) resolve(r *http.Request) (*Route, bool) {
+	for i := range c.Routes {
+		if c.Routes[i].Match.matches(r) {
+			return &c.Routes[i], true
+		}
+	}
+	if c.Default != nil {
+		return c.Default, true
+	}
+	return nil, false
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConfigResolveMatcherPriority(t *testing.T) {
+	cfg := &Config{
+		Routes: []Route{
+			{Name: "ssh", Match: Matcher{PathPrefix: "/ssh"}, Forward: "localhost:22"},
+			{Name: "worker", Match: Matcher{CFWorkerHeader: "internal"}, Forward: "localhost:9000"},
+			{Name: "rdp", Match: Matcher{HostHeader: "rdp.example.com"}, Forward: "localhost:3389"},
+			{Name: "blocked", Match: Matcher{PathPrefix: "/blocked"}, Reject: true},
+		},
+		Default: &Route{Name: "default", Forward: "localhost:80"},
+	}
+
+	cases := []struct {
+		name      string
+		configure func(r *http.Request)
+		want      string // expected route name, "" for no match
+		wantOK    bool
+	}{
+		{
+			name:      "path prefix wins",
+			configure: func(r *http.Request) { r.URL.Path = "/ssh/foo" },
+			want:      "ssh",
+			wantOK:    true,
+		},
+		{
+			name: "first match wins over a later one that would also match",
+			configure: func(r *http.Request) {
+				r.URL.Path = "/ssh/foo"
+				r.Host = "rdp.example.com"
+			},
+			want:   "ssh",
+			wantOK: true,
+		},
+		{
+			name:      "cf worker header",
+			configure: func(r *http.Request) { r.Header.Set("Cf-Worker", "internal") },
+			want:      "worker",
+			wantOK:    true,
+		},
+		{
+			name:      "host header",
+			configure: func(r *http.Request) { r.Host = "rdp.example.com" },
+			want:      "rdp",
+			wantOK:    true,
+		},
+		{
+			name:      "reject route still wins priority",
+			configure: func(r *http.Request) { r.URL.Path = "/blocked/x" },
+			want:      "blocked",
+			wantOK:    true,
+		},
+		{
+			name:      "falls back to default",
+			configure: func(r *http.Request) {},
+			want:      "default",
+			wantOK:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			tc.configure(r)
+
+			route, ok := cfg.resolve(r)
+			if ok != tc.wantOK {
+				t.Fatalf("resolve() ok = %v, want %v", ok, tc.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if route.Name != tc.want {
+				t.Errorf("resolve() route = %q, want %q", route.Name, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigResolveNoMatchNoDefault(t *testing.T) {
+	cfg := &Config{
+		Routes: []Route{
+			{Name: "ssh", Match: Matcher{PathPrefix: "/ssh"}, Forward: "localhost:22"},
+		},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/other", nil)
+	if _, ok := cfg.resolve(r); ok {
+		t.Error("resolve() with no matching route and no default = true, want false")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Encoding represents payload bytes over the GET/POST hex-poll transport.
+// hex is the historical default and safest across CDNs; base64url and raw
+// trade that safety for less overhead.
+type Encoding interface {
+	Name() string
+	ContentType() string
+	Encode(w io.Writer, p []byte) error
+	Decode(r io.Reader) ([]byte, error)
+}
+
+type hexEncoding struct{}
+
+func (hexEncoding) Name() string        { return "hex" }
+func (hexEncoding) ContentType() string { return "application/octet-stream" }
+func (hexEncoding) Encode(w io.Writer, p []byte) error {
+	_, err := io.WriteString(w, hex.EncodeToString(p))
+	return err
+}
+func (hexEncoding) Decode(r io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(raw)))
+}
+
+type base64urlEncoding struct{}
+
+func (base64urlEncoding) Name() string        { return "b64url" }
+func (base64urlEncoding) ContentType() string { return "text/plain; charset=utf-8" }
+func (base64urlEncoding) Encode(w io.Writer, p []byte) error {
+	_, err := io.WriteString(w, base64.RawURLEncoding.EncodeToString(p))
+	return err
+}
+func (base64urlEncoding) Decode(r io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimSpace(string(raw)))
+}
+
+// rawEncoding sends the payload untransformed. It is only safe when the
+// CDN passes request/response bodies through byte-for-byte.
+type rawEncoding struct{}
+
+func (rawEncoding) Name() string        { return "raw" }
+func (rawEncoding) ContentType() string { return "application/octet-stream" }
+func (rawEncoding) Encode(w io.Writer, p []byte) error {
+	_, err := w.Write(p)
+	return err
+}
+func (rawEncoding) Decode(r io.Reader) ([]byte, error) {
+	return io.ReadAll(r)
+}
+
+var encodings = map[string]Encoding{
+	"hex":    hexEncoding{},
+	"b64url": base64urlEncoding{},
+	"raw":    rawEncoding{},
+}
+
+// negotiateEncoding parses the client's X-DF-Enc preference list (e.g.
+// "b64url,hex") and returns the first encoding the server supports,
+// falling back to hex when the header is absent or nothing matches.
+func negotiateEncoding(r *http.Request) Encoding {
+	for _, name := range strings.Split(r.Header.Get("X-DF-Enc"), ",") {
+		name = strings.TrimSuffix(strings.TrimSpace(name), "+framed")
+		if enc, ok := encodings[name]; ok {
+			return enc
+		}
+	}
+	return hexEncoding{}
+}
+
+// isFramedRequest reports whether the client opted into length-framed
+// multi-message coalescing, e.g. "X-DF-Enc: hex+framed".
+func isFramedRequest(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("X-DF-Enc"), "+framed")
+}
+
+// FrameMessages joins several backend reads into one payload, each prefixed
+// with its 4-byte big-endian length, so multiple reads coalesced into a
+// single response can be told apart unambiguously instead of concatenated
+// into one opaque blob.
+func FrameMessages(messages [][]byte) []byte {
+	var buf bytes.Buffer
+	var lenPrefix [4]byte
+	for _, m := range messages {
+		binary.BigEndian.PutUint32(lenPrefix[:], uint32(len(m)))
+		buf.Write(lenPrefix[:])
+		buf.Write(m)
+	}
+	return buf.Bytes()
+}
+
+// UnframeMessages reverses FrameMessages.
+func UnframeMessages(data []byte) ([][]byte, error) {
+	var messages [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("encoding: truncated frame length")
+		}
+		n := binary.BigEndian.Uint32(data[:4])
+		data = data[4:]
+		if uint64(len(data)) < uint64(n) {
+			return nil, fmt.Errorf("encoding: truncated frame body")
+		}
+		messages = append(messages, data[:n])
+		data = data[n:]
+	}
+	return messages, nil
+}
+
+// maxStreamFrameSize bounds a single frame ReadFrame will allocate for, so a
+// corrupt or hostile length prefix on a long-lived stream can't force an
+// unbounded allocation.
+const maxStreamFrameSize = 1 << 20
+
+// ReadFrame reads one message in the same length-prefixed wire format as
+// FrameMessages/UnframeMessages, for callers that must frame incrementally
+// off an open stream rather than unframing an already-buffered blob. It
+// returns io.EOF when r is exhausted cleanly between frames.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var lenPrefix [4]byte
+	if _, err := io.ReadFull(r, lenPrefix[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(lenPrefix[:])
+	if n > maxStreamFrameSize {
+		return nil, fmt.Errorf("encoding: frame too large (%d bytes)", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
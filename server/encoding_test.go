@@ -0,0 +1,127 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFrameUnframeMessagesRoundTrip(t *testing.T) {
+	messages := [][]byte{
+		[]byte("hello"),
+		{},
+		[]byte("a slightly longer second message"),
+		[]byte("x"),
+	}
+
+	framed := FrameMessages(messages)
+	got, err := UnframeMessages(framed)
+	if err != nil {
+		t.Fatalf("UnframeMessages: %v", err)
+	}
+	if len(got) != len(messages) {
+		t.Fatalf("got %d messages, want %d", len(got), len(messages))
+	}
+	for i, want := range messages {
+		if !bytes.Equal(got[i], want) {
+			t.Errorf("message %d = %q, want %q", i, got[i], want)
+		}
+	}
+}
+
+func TestUnframeMessagesEmpty(t *testing.T) {
+	got, err := UnframeMessages(nil)
+	if err != nil {
+		t.Fatalf("UnframeMessages(nil): %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %d messages, want 0", len(got))
+	}
+}
+
+func TestUnframeMessagesTruncated(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated length prefix", []byte{0, 0, 0}},
+		{"truncated body", append([]byte{0, 0, 0, 5}, []byte("ab")...)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := UnframeMessages(tc.data); err == nil {
+				t.Error("UnframeMessages on truncated input = nil error, want error")
+			}
+		})
+	}
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	payload := []byte("tunnel payload bytes \x00\x01\xff")
+
+	for name, enc := range encodings {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := enc.Encode(&buf, payload); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			got, err := enc.Decode(&buf)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("got %q, want %q", got, payload)
+			}
+		})
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		header string
+		want   string
+	}{
+		{"", "hex"},
+		{"b64url", "b64url"},
+		{"b64url,hex", "b64url"},
+		{"raw", "raw"},
+		{"unknown,hex", "hex"},
+		{"unknown", "hex"},
+		{"hex+framed", "hex"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.header, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				r.Header.Set("X-DF-Enc", tc.header)
+			}
+			if got := negotiateEncoding(r).Name(); got != tc.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", tc.header, got, tc.want)
+			}
+		})
+	}
+}
@@ -23,8 +23,8 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/tls"
-	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
@@ -34,16 +34,32 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/ASkyeye/darkflare/metrics"
+	"github.com/ASkyeye/darkflare/psk"
 )
 
 type Session struct {
 	conn       net.Conn
+	createdAt  time.Time
 	lastActive time.Time
 	buffer     []byte
-	mu         sync.Mutex
+	route      *Route
+	sealer     *psk.Sealer
+	opener     *psk.Opener
+
+	remoteIP string
+	backend  string
+	bytesIn  int64
+	bytesOut int64
+
+	mu sync.Mutex
 }
 
 type Server struct {
@@ -54,9 +70,20 @@ type Server struct {
 	appCommand  string
 	isAppMode   bool
 	allowDirect bool
+
+	configPath string
+	config     atomic.Pointer[Config]
+
+	pskKey []byte
+
+	streamTimeout time.Duration
 }
 
-func NewServer(destHost, destPort string, appCommand string, debug bool, allowDirect bool) *Server {
+// defaultStreamTimeout bounds how long an X-DF-Stream response is held open
+// when neither the client nor the backend ends it first.
+const defaultStreamTimeout = 30 * time.Second
+
+func NewServer(destHost, destPort string, appCommand string, debug bool, allowDirect bool, pskKey []byte) *Server {
 	s := &Server{
 		destHost:    destHost,
 		destPort:    destPort,
@@ -64,6 +91,9 @@ func NewServer(destHost, destPort string, appCommand string, debug bool, allowDi
 		appCommand:  appCommand,
 		isAppMode:   appCommand != "",
 		allowDirect: allowDirect,
+		pskKey:      pskKey,
+
+		streamTimeout: defaultStreamTimeout,
 	}
 
 	if s.isAppMode && s.debug {
@@ -74,6 +104,47 @@ func NewServer(destHost, destPort string, appCommand string, debug bool, allowDi
 	return s
 }
 
+// NewServerFromConfig starts a multi-route server driven entirely by a YAML
+// config file, replacing the flat -d/-a flags. The config is hot-reloaded on
+// SIGHUP by swapping s.config, so in-flight sessions keep the route they
+// were dialed with while new sessions see the updated file.
+func NewServerFromConfig(configPath string, debug bool, allowDirect bool, pskKey []byte) (*Server, error) {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		debug:       debug,
+		allowDirect: allowDirect,
+		configPath:  configPath,
+		pskKey:      pskKey,
+
+		streamTimeout: defaultStreamTimeout,
+	}
+	s.config.Store(cfg)
+
+	go s.watchConfigReload()
+	go s.cleanupSessions()
+	return s, nil
+}
+
+// watchConfigReload reloads s.configPath on SIGHUP and atomically swaps it
+// in, so operators can add or change routes without dropping connections.
+func (s *Server) watchConfigReload() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	for range sighup {
+		cfg, err := LoadConfig(s.configPath)
+		if err != nil {
+			log.Printf("Config reload failed, keeping previous config: %v", err)
+			continue
+		}
+		s.config.Store(cfg)
+		log.Printf("Reloaded config from %s (%d routes)", s.configPath, len(cfg.Routes))
+	}
+}
+
 func (s *Server) cleanupSessions() {
 	for {
 		time.Sleep(time.Minute)
@@ -84,6 +155,7 @@ func (s *Server) cleanupSessions() {
 			if now.Sub(session.lastActive) > 5*time.Minute {
 				session.conn.Close()
 				s.sessions.Delete(key)
+				metrics.SessionClosed(session.route.label(), now.Sub(session.createdAt))
 			}
 			session.mu.Unlock()
 			return true
@@ -92,11 +164,18 @@ func (s *Server) cleanupSessions() {
 }
 
 func (s *Server) handleApplication(w http.ResponseWriter, r *http.Request) {
+	s.handleApplicationCommand(w, r, s.appCommand)
+}
+
+// handleApplicationCommand launches command and waits for it to exit,
+// logging its stdout/stderr. It backs both -a app-mode and `app:` routes
+// from a -config file.
+func (s *Server) handleApplicationCommand(w http.ResponseWriter, r *http.Request, command string) {
 	if s.debug {
 		log.Printf("Handling application request from %s", r.Header.Get("Cf-Connecting-Ip"))
 	}
 
-	parts := strings.Fields(s.appCommand)
+	parts := strings.Fields(command)
 	if len(parts) == 0 {
 		http.Error(w, "Invalid application command", http.StatusInternalServerError)
 		return
@@ -106,7 +185,7 @@ func (s *Server) handleApplication(w http.ResponseWriter, r *http.Request) {
 	cmd.Env = os.Environ()
 
 	if s.debug {
-		log.Printf("Launching application: %s", s.appCommand)
+		log.Printf("Launching application: %s", command)
 	}
 
 	stdout, err := cmd.StdoutPipe()
@@ -164,7 +243,24 @@ func (s *Server) handleApplication(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// sessionIDFromRequest extracts the session identifier from the headers the
+// client may set it in, falling back to the Cloudflare-assigned values.
+func sessionIDFromRequest(r *http.Request) string {
+	if id := r.Header.Get("X-Ephemeral"); id != "" {
+		return id
+	}
+	if id := r.Header.Get("Cf-Ray"); id != "" {
+		return id
+	}
+	return r.Header.Get("Cf-Connecting-Ip")
+}
+
 func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
+	if s.isAppMode && isWebSocketUpgrade(r) {
+		s.handleWebSocket(w, r, sessionIDFromRequest(r))
+		return
+	}
+
 	if s.isAppMode {
 		s.handleApplication(w, r)
 		return
@@ -199,15 +295,23 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Expires", "0")
 	w.Header().Set("Content-Type", "application/octet-stream")
 
-	// Try to get session ID from various possible headers
-	sessionID := r.Header.Get("X-Ephemeral")
-	if sessionID == "" {
-		// Try Cloudflare-specific headers
-		sessionID = r.Header.Get("Cf-Ray")
-		if sessionID == "" {
-			// Could also try other headers or generate a session ID based on IP
-			sessionID = r.Header.Get("Cf-Connecting-Ip")
+	// Try to get session ID from various possible headers, authenticating it
+	// against -psk before anything (including net.Dial) happens on its behalf.
+	var sessionID string
+	var sealer *psk.Sealer
+	var opener *psk.Opener
+	if s.pskKey != nil {
+		var ok bool
+		sessionID, sealer, opener, ok = s.authenticateSession(r)
+		if !ok {
+			if s.debug {
+				log.Printf("Error: PSK authentication failed from %s", r.Header.Get("Cf-Connecting-Ip"))
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
 		}
+	} else {
+		sessionID = sessionIDFromRequest(r)
 	}
 
 	if sessionID == "" {
@@ -218,38 +322,90 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// WebSocket transport bypasses the hex poll loop entirely: once upgraded,
+	// conn<->ws is bridged directly and the session is torn down on close.
+	if isWebSocketUpgrade(r) {
+		s.handleWebSocket(w, r, sessionID)
+		return
+	}
+
+	// An existing session stays pinned to the route it was dialed with: a
+	// config reload that narrows or rejects a route must not affect polls
+	// against a tunnel that's already established.
 	var session *Session
+	var route *Route
 	sessionInterface, exists := s.sessions.Load(sessionID)
-	if !exists {
-		conn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", s.destHost, s.destPort))
+	if exists {
+		session = sessionInterface.(*Session)
+		route = session.route
+	} else {
+		if s.usingConfig() {
+			var handled bool
+			route, handled = s.dispatchRoute(w, r)
+			if handled {
+				return
+			}
+		}
+
+		destHost, destPort := s.destHost, s.destPort
+		if route != nil {
+			destHost, destPort, _ = net.SplitHostPort(route.Forward)
+		}
+
+		conn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", destHost, destPort))
 		if err != nil {
+			metrics.DialError(route.label())
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		now := time.Now()
 		session = &Session{
 			conn:       conn,
-			lastActive: time.Now(),
+			createdAt:  now,
+			lastActive: now,
 			buffer:     make([]byte, 0),
+			route:      route,
+			sealer:     sealer,
+			opener:     opener,
+			remoteIP:   r.Header.Get("Cf-Connecting-Ip"),
+			backend:    fmt.Sprintf("%s:%s", destHost, destPort),
 		}
 		s.sessions.Store(sessionID, session)
-	} else {
-		session = sessionInterface.(*Session)
+		metrics.SessionOpened(route.label())
 	}
 
 	session.mu.Lock()
 	defer session.mu.Unlock()
 	session.lastActive = time.Now()
 
+	if r.Method == http.MethodPost && isStreamingRequest(r) {
+		s.handleStreamingPOST(w, r, session, sessionID)
+		return
+	}
+
+	enc := negotiateEncoding(r)
+	framed := isFramedRequest(r)
+
 	if r.Method == http.MethodPost {
-		data, err := io.ReadAll(r.Body)
+		data, err := enc.Decode(r.Body)
 		if err != nil {
 			if s.debug {
-				log.Printf("Error reading request body: %v", err)
+				log.Printf("Error decoding request body (%s): %v", enc.Name(), err)
 			}
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if session.opener != nil && len(data) > 0 {
+			data, err = session.opener.Open(data)
+			if err != nil {
+				if s.debug {
+					log.Printf("Error decrypting request body for session %s: %v", sessionID[:8], err)
+				}
+				http.Error(w, "Bad session frame", http.StatusBadRequest)
+				return
+			}
+		}
 		if len(data) > 0 {
 			if s.debug {
 				log.Printf("POST: Writing %d bytes to connection for session %s",
@@ -265,13 +421,21 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			atomic.AddInt64(&session.bytesIn, int64(len(data)))
+			metrics.BytesIn.WithLabelValues(route.label()).Add(float64(len(data)))
 		}
 		return
 	}
 
+	if isStreamingRequest(r) {
+		s.handleStreamingGET(w, r, session, sessionID)
+		return
+	}
+
 	// For GET requests, read any available data
 	buffer := make([]byte, 8192)
-	var readData []byte
+	var reads [][]byte
+	var readTotal int
 
 	for {
 		session.conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
@@ -293,7 +457,10 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 					sessionID[:8],
 				)
 			}
-			readData = append(readData, buffer[:n]...)
+			chunk := make([]byte, n)
+			copy(chunk, buffer[:n])
+			reads = append(reads, chunk)
+			readTotal += n
 		}
 		if n < len(buffer) {
 			break
@@ -301,17 +468,39 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Only encode and send if we have data
-	if len(readData) > 0 {
-		encoded := hex.EncodeToString(readData)
+	if readTotal > 0 {
+		atomic.AddInt64(&session.bytesOut, int64(readTotal))
+		metrics.BytesOut.WithLabelValues(route.label()).Add(float64(readTotal))
+
+		var readData []byte
+		if framed {
+			readData = FrameMessages(reads)
+		} else {
+			readData = bytes.Join(reads, nil)
+		}
+
+		if session.sealer != nil {
+			readData = session.sealer.Seal(readData)
+		}
+		w.Header().Set("Content-Type", enc.ContentType())
+		var out bytes.Buffer
+		if err := enc.Encode(&out, readData); err != nil {
+			if s.debug {
+				log.Printf("Error encoding response (%s) for session %s: %v", enc.Name(), sessionID[:8], err)
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		if s.debug {
-			log.Printf("Response: Sending %d bytes (encoded: %d bytes) for session %s path %s",
-				len(readData),
-				len(encoded),
+			log.Printf("Response: Sending %d bytes (encoded: %d bytes, %s) for session %s path %s",
+				readTotal,
+				out.Len(),
+				enc.Name(),
 				sessionID[:8],
 				r.URL.Path,
 			)
 		}
-		w.Write([]byte(encoded))
+		w.Write(out.Bytes())
 	} else if s.debug {
 		log.Printf("Response: No data to send for session %s path %s",
 			sessionID[:8],
@@ -328,6 +517,11 @@ func main() {
 	var debug bool
 	var allowDirect bool
 	var appCommand string
+	var configPath string
+	var preSharedKey string
+	var streamTimeoutSecs int
+	var metricsAddr string
+	var metricsToken string
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "DarkFlare Server - TCP-over-CDN tunnel server component\n")
@@ -344,6 +538,16 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  -a        Application mode: launches a command instead of forwarding\n")
 		fmt.Fprintf(os.Stderr, "            Example: 'sshd -i' or 'pppd noauth'\n")
 		fmt.Fprintf(os.Stderr, "            Note: Cannot be used with -d flag\n\n")
+		fmt.Fprintf(os.Stderr, "  -config   Path to a YAML routing config (replaces -d/-a)\n")
+		fmt.Fprintf(os.Stderr, "            Routes by SNI, Host header, path prefix or Cf-Worker header\n")
+		fmt.Fprintf(os.Stderr, "            Reloaded on SIGHUP\n\n")
+		fmt.Fprintf(os.Stderr, "  -psk      Pre-shared key: require and verify HMAC-authenticated\n")
+		fmt.Fprintf(os.Stderr, "            X-Ephemeral session IDs and encrypt poll/stream session traffic\n")
+		fmt.Fprintf(os.Stderr, "            WebSocket payloads are not encrypted; -a mode requests are not checked\n\n")
+		fmt.Fprintf(os.Stderr, "  -stream-timeout  Seconds to hold an X-DF-Stream response open (default 30)\n\n")
+		fmt.Fprintf(os.Stderr, "  -metrics  Address to serve Prometheus /metrics and /debug/sessions on\n")
+		fmt.Fprintf(os.Stderr, "            Example: :9090\n")
+		fmt.Fprintf(os.Stderr, "  -metrics-token  Bearer token required by /debug/sessions\n\n")
 		fmt.Fprintf(os.Stderr, "  -debug    Enable debug logging\n")
 		fmt.Fprintf(os.Stderr, "  -allow-direct  Allow direct connections without Cloudflare headers\n")
 		fmt.Fprintf(os.Stderr, "            Warning: Not recommended for production use\n\n")
@@ -360,6 +564,11 @@ func main() {
 	flag.StringVar(&certFile, "c", "", "")
 	flag.StringVar(&keyFile, "k", "", "")
 	flag.StringVar(&appCommand, "a", "", "")
+	flag.StringVar(&configPath, "config", "", "")
+	flag.StringVar(&preSharedKey, "psk", "", "")
+	flag.IntVar(&streamTimeoutSecs, "stream-timeout", int(defaultStreamTimeout/time.Second), "")
+	flag.StringVar(&metricsAddr, "metrics", "", "")
+	flag.StringVar(&metricsToken, "metrics-token", "", "")
 	flag.BoolVar(&debug, "debug", false, "")
 	flag.BoolVar(&allowDirect, "allow-direct", false, "")
 	flag.Parse()
@@ -395,12 +604,37 @@ func main() {
 		log.Fatal("Origin host must be a local IP address")
 	}
 
-	server := NewServer(destHost, destPort, appCommand, debug, allowDirect)
+	var pskKey []byte
+	if preSharedKey != "" {
+		pskKey = []byte(preSharedKey)
+	}
+
+	var server *Server
+	if configPath != "" {
+		if dest != "" || appCommand != "" {
+			log.Fatal("-config cannot be combined with -d or -a")
+		}
+		server, err = NewServerFromConfig(configPath, debug, allowDirect, pskKey)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		log.Printf("Routing requests from config %s", configPath)
+	} else {
+		server = NewServer(destHost, destPort, appCommand, debug, allowDirect, pskKey)
+	}
+	server.streamTimeout = time.Duration(streamTimeoutSecs) * time.Second
+
+	if metricsAddr != "" {
+		server.StartMetricsServer(metricsAddr, metricsToken)
+	}
 
 	log.Printf("DarkFlare server running on %s://%s:%s", originURL.Scheme, originHost, originPort)
 	if allowDirect {
 		log.Printf("Warning: Direct connections allowed (no Cloudflare required)")
 	}
+	if pskKey != nil {
+		log.Printf("Pre-shared key authentication and session encryption enabled")
+	}
 
 	// Start server with appropriate protocol
 	if originURL.Scheme == "https" {
@@ -0,0 +1,110 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// sessionInfo is the JSON shape returned by /debug/sessions: enough to
+// debug a stuck tunnel without leaking the full session ID.
+type sessionInfo struct {
+	SessionID  string    `json:"session_id"`
+	RemoteIP   string    `json:"remote_ip"`
+	Backend    string    `json:"backend"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	LastActive time.Time `json:"last_active"`
+}
+
+// StartMetricsServer serves Prometheus metrics and the /debug/sessions
+// introspection endpoint on addr. It runs in its own goroutine on its own
+// listener, separate from the tunnel's origin listener.
+func (s *Server) StartMetricsServer(addr, debugToken string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/debug/sessions", s.handleDebugSessions(debugToken))
+
+	go func() {
+		log.Printf("Metrics listening on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
+}
+
+// handleDebugSessions lists in-flight sessions as JSON, gated behind a
+// bearer token since it reveals remote IPs and backends. The sync.Map the
+// sessions live in is otherwise a black box to operators debugging a stuck
+// tunnel.
+func (s *Server) handleDebugSessions(debugToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if debugToken == "" || !validToken(r, debugToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var sessions []sessionInfo
+		s.sessions.Range(func(key, value interface{}) bool {
+			sessionID := key.(string)
+			session := value.(*Session)
+			sessions = append(sessions, sessionInfo{
+				SessionID:  truncateSessionID(sessionID),
+				RemoteIP:   session.remoteIP,
+				Backend:    session.backend,
+				BytesIn:    atomic.LoadInt64(&session.bytesIn),
+				BytesOut:   atomic.LoadInt64(&session.bytesOut),
+				LastActive: session.lastActive,
+			})
+			return true
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sessions)
+	}
+}
+
+// validToken reports whether r carries the expected bearer token.
+func validToken(r *http.Request, token string) bool {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(prefix) || auth[:len(prefix)] != prefix {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(token)) == 1
+}
+
+// truncateSessionID returns at most the first 8 characters of id, matching
+// the truncation already used in debug logging.
+func truncateSessionID(id string) string {
+	if len(id) <= 8 {
+		return id
+	}
+	return id[:8]
+}
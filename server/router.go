@@ -0,0 +1,59 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+)
+
+// usingConfig reports whether the server was started with -config and
+// should route per-request instead of using the flat -d/-a flags.
+func (s *Server) usingConfig() bool {
+	return s.configPath != ""
+}
+
+// dispatchRoute resolves the route matching r against the live config. It
+// returns (route, false) when the request should fall through to the normal
+// forward-mode session handling with that route pinned; it returns
+// (nil, true) when it has already written the response itself (reject,
+// app, or no-match) and the caller must not do anything further.
+func (s *Server) dispatchRoute(w http.ResponseWriter, r *http.Request) (*Route, bool) {
+	cfg := s.config.Load()
+
+	route, ok := cfg.resolve(r)
+	if !ok {
+		http.Error(w, "No matching route", http.StatusNotFound)
+		return nil, true
+	}
+
+	if route.Reject {
+		http.Error(w, "Rejected by route policy", http.StatusForbidden)
+		return nil, true
+	}
+
+	if route.App != "" {
+		s.handleApplicationCommand(w, r, route.App)
+		return nil, true
+	}
+
+	return route, false
+}
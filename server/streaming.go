@@ -0,0 +1,193 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/ASkyeye/darkflare/metrics"
+)
+
+// streamChunkBuffer bounds how many unread backend chunks a GET stream will
+// hold before backend reads block, providing backpressure instead of an
+// unbounded memory buildup when the client is slow to poll.
+const streamChunkBuffer = 32
+
+// isStreamingRequest reports whether r opted into long-lived streaming mode.
+func isStreamingRequest(r *http.Request) bool {
+	return r.Header.Get("X-DF-Stream") == "1"
+}
+
+// handleStreamingGET holds the response open, relaying backend reads to w as
+// length-framed chunks (see ReadFrame/FrameMessages) in the negotiated
+// encoding, with a flush after each one. It replaces the 50ms poll loop's
+// per-request RTT with a single long-lived response, for as long as
+// s.streamTimeout, the backend connection, or the client allow.
+func (s *Server) handleStreamingGET(w http.ResponseWriter, r *http.Request, session *Session, sessionID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	enc := negotiateEncoding(r)
+	w.Header().Set("Content-Type", enc.ContentType())
+
+	ctx := r.Context()
+	chunks := make(chan []byte, streamChunkBuffer)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(chunks)
+		buf := make([]byte, 8192)
+		for {
+			session.conn.SetReadDeadline(time.Now().Add(s.streamTimeout))
+			n, err := session.conn.Read(buf)
+			if n > 0 {
+				chunk := make([]byte, n)
+				copy(chunk, buf[:n])
+				select {
+				case chunks <- chunk:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				if s.debug && err != io.EOF {
+					if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+						log.Printf("Stream session %s: backend read error: %v", sessionID[:8], err)
+					}
+				}
+				return
+			}
+		}
+	}()
+
+	deadline := time.NewTimer(s.streamTimeout)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if s.debug {
+				log.Printf("Stream session %s: client disconnected", sessionID[:8])
+			}
+			return
+		case <-deadline.C:
+			return
+		case chunk, ok := <-chunks:
+			if !ok {
+				// Backend closed the connection; end the stream so the
+				// client can fall back to the poll path or give up.
+				return
+			}
+			atomic.AddInt64(&session.bytesOut, int64(len(chunk)))
+			metrics.BytesOut.WithLabelValues(session.route.label()).Add(float64(len(chunk)))
+			if session.sealer != nil {
+				chunk = session.sealer.Seal(chunk)
+			}
+			var out bytes.Buffer
+			if err := enc.Encode(&out, chunk); err != nil {
+				if s.debug {
+					log.Printf("Stream session %s: error encoding chunk (%s): %v", sessionID[:8], enc.Name(), err)
+				}
+				return
+			}
+			if _, err := w.Write(FrameMessages([][]byte{out.Bytes()})); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// handleStreamingPOST drains r.Body as a sequence of length-framed chunks
+// (see ReadFrame), decoding then decrypting (if the session is
+// PSK-authenticated) each one before writing it to the backend. Newline
+// delimiting doesn't work here: raw encoding and PSK-sealed ciphertext can
+// both contain literal 0x0A bytes, so only an explicit length prefix can
+// tell successive chunks apart.
+func (s *Server) handleStreamingPOST(w http.ResponseWriter, r *http.Request, session *Session, sessionID string) {
+	enc := negotiateEncoding(r)
+
+	var total int
+	for {
+		frame, err := ReadFrame(r.Body)
+		if err != nil {
+			if err != io.EOF {
+				if s.debug {
+					log.Printf("Stream session %s: error reading request frame: %v", sessionID[:8], err)
+				}
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			break
+		}
+
+		data, err := enc.Decode(bytes.NewReader(frame))
+		if err != nil {
+			if s.debug {
+				log.Printf("Stream session %s: error decoding chunk (%s): %v", sessionID[:8], enc.Name(), err)
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if session.opener != nil && len(data) > 0 {
+			data, err = session.opener.Open(data)
+			if err != nil {
+				if s.debug {
+					log.Printf("Stream session %s: error decrypting chunk: %v", sessionID[:8], err)
+				}
+				http.Error(w, "Bad session frame", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+
+		if _, err := session.conn.Write(data); err != nil {
+			if s.debug {
+				log.Printf("Stream session %s: error writing to backend: %v", sessionID[:8], err)
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		total += len(data)
+		atomic.AddInt64(&session.bytesIn, int64(len(data)))
+		metrics.BytesIn.WithLabelValues(session.route.label()).Add(float64(len(data)))
+	}
+
+	if s.debug {
+		log.Printf("Stream session %s: streamed %d bytes to backend", sessionID[:8], total)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright (c) Barrett Lyon
+// blyon@blyon.com
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/ASkyeye/darkflare/metrics"
+)
+
+// wsUpgrader upgrades raw hex/POST-GET polling requests into a bidirectional
+// binary stream. It intentionally skips origin checking: for forwarding
+// sessions the tunnel's trust boundary is Cloudflare (or -allow-direct),
+// enforced earlier in handleRequest. In -a app mode, handleRequest hands
+// WebSocket upgrades over before that check, as it does all -a requests.
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  16 * 1024,
+	WriteBufferSize: 16 * 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// isWebSocketUpgrade reports whether r is requesting a WebSocket upgrade.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// handleWebSocket bridges a single WebSocket connection directly to the
+// backend (or application) connection, replacing the hex poll loop entirely
+// for this session. It blocks until either side closes, then removes the
+// session from s.sessions.
+//
+// Route resolution happens before the upgrade, the same as the poll path in
+// handleRequest: under -config, a WebSocket request is just another request
+// that needs dispatchRoute to pick (or reject) a backend, and dispatchRoute
+// writes its response through w, which only works pre-upgrade.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request, sessionID string) {
+	if s.isAppMode {
+		ws, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			if s.debug {
+				log.Printf("WebSocket upgrade failed for session %s: %v", sessionID, err)
+			}
+			return
+		}
+		defer ws.Close()
+		defer s.sessions.Delete(sessionID)
+
+		s.bridgeApplicationWebSocket(ws, sessionID)
+		return
+	}
+
+	var route *Route
+	if s.usingConfig() {
+		var handled bool
+		route, handled = s.dispatchRoute(w, r)
+		if handled {
+			return
+		}
+	}
+
+	destHost, destPort := s.destHost, s.destPort
+	if route != nil {
+		destHost, destPort, _ = net.SplitHostPort(route.Forward)
+	}
+	backend := net.JoinHostPort(destHost, destPort)
+
+	conn, err := net.Dial("tcp", backend)
+	if err != nil {
+		metrics.DialError(route.label())
+		if s.debug {
+			log.Printf("WebSocket dial failed for session %s: %v", sessionID, err)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	ws, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		if s.debug {
+			log.Printf("WebSocket upgrade failed for session %s: %v", sessionID, err)
+		}
+		return
+	}
+	defer ws.Close()
+
+	now := time.Now()
+	session := &Session{
+		conn:       conn,
+		createdAt:  now,
+		lastActive: now,
+		route:      route,
+		remoteIP:   r.Header.Get("Cf-Connecting-Ip"),
+		backend:    backend,
+	}
+	s.sessions.Store(sessionID, session)
+	metrics.SessionOpened(route.label())
+	defer func() {
+		// Only report the close here if cleanupSessions' idle sweep hasn't
+		// already deleted and reported this session out from under us.
+		if _, ok := s.sessions.LoadAndDelete(sessionID); ok {
+			metrics.SessionClosed(route.label(), time.Since(session.createdAt))
+		}
+	}()
+
+	if s.debug {
+		log.Printf("WebSocket session %s bridged to %s", sessionID, backend)
+	}
+
+	s.bridgeConnWebSocket(conn, ws, sessionID, session)
+}
+
+// bridgeApplicationWebSocket launches s.appCommand and pipes its stdin/stdout
+// over ws, giving -a app-mode the same pty-like, poll-free transport as
+// forwarding mode.
+func (s *Server) bridgeApplicationWebSocket(ws *websocket.Conn, sessionID string) {
+	parts := strings.Fields(s.appCommand)
+	if len(parts) == 0 {
+		ws.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "invalid application command"))
+		return
+	}
+
+	cmd := exec.Command(parts[0], parts[1:]...)
+	cmd.Env = os.Environ()
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Printf("Failed to create stdin pipe: %v", err)
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Printf("Failed to create stdout pipe: %v", err)
+		return
+	}
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		log.Printf("Failed to start application: %v", err)
+		return
+	}
+	defer cmd.Process.Kill()
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		defer close(done)
+		buf := make([]byte, 16*1024)
+		for {
+			n, err := stdout.Read(buf)
+			if n > 0 {
+				if werr := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			msgType, data, err := ws.ReadMessage()
+			if err != nil {
+				stdin.Close()
+				return
+			}
+			if msgType != websocket.BinaryMessage || len(data) == 0 {
+				continue
+			}
+			if _, err := stdin.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	<-done
+	if s.debug {
+		log.Printf("WebSocket application session %s ended", sessionID)
+	}
+	cmd.Wait()
+}
+
+// bridgeConnWebSocket copies bytes in both directions between conn and ws
+// using binary frames until either side closes. It touches session.lastActive
+// on every frame so cleanupSessions' idle sweep doesn't kill a long-lived,
+// actively-bridged tunnel out from under it.
+func (s *Server) bridgeConnWebSocket(conn net.Conn, ws *websocket.Conn, sessionID string, session *Session) {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		buf := make([]byte, 16*1024)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				session.mu.Lock()
+				session.lastActive = time.Now()
+				session.mu.Unlock()
+				atomic.AddInt64(&session.bytesOut, int64(n))
+				metrics.BytesOut.WithLabelValues(session.route.label()).Add(float64(n))
+				if werr := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF && s.debug {
+					log.Printf("WebSocket session %s: backend read error: %v", sessionID, err)
+				}
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		for {
+			msgType, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msgType != websocket.BinaryMessage || len(data) == 0 {
+				continue
+			}
+			session.mu.Lock()
+			session.lastActive = time.Now()
+			session.mu.Unlock()
+			if _, err := conn.Write(data); err != nil {
+				if s.debug {
+					log.Printf("WebSocket session %s: backend write error: %v", sessionID, err)
+				}
+				return
+			}
+			atomic.AddInt64(&session.bytesIn, int64(len(data)))
+			metrics.BytesIn.WithLabelValues(session.route.label()).Add(float64(len(data)))
+		}
+	}()
+
+	<-done
+	conn.Close()
+	ws.Close()
+	<-done
+}